@@ -0,0 +1,53 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+
+  "github.com/huchangwei/agollo"
+)
+
+// 从阿波罗或模拟的配置中心HttpConfigServer拉取全部namespace，生成快照，
+// 通过 go:embed 编译进程序，作为 Conf.Embedded 在远程与本地备份都不可用时使用
+//
+//   go run SnapshotGen.go -conf ../testapp.yaml -out agollo-snapshot.json
+func main() {
+  confPath := flag.String("conf", "app.yaml", "配置文件路径")
+  out := flag.String("out", "agollo-snapshot.json", "快照输出路径")
+  flag.Parse()
+
+  if err := generate(*confPath, *out); err != nil {
+    fmt.Println("snapshot error:", err)
+    os.Exit(1)
+  }
+}
+
+func generate(confPath, out string) error {
+  conf, err := agollo.NewConf(confPath)
+  if err != nil {
+    return err
+  }
+  // 只从远程拉取，不读写本地备份
+  conf.Persist = agollo.PersistNever
+
+  client := agollo.NewClient(conf)
+  if err := client.Start(); err != nil {
+    return err
+  }
+  defer client.Stop()
+  // Start 在部分 namespace 拉取失败时也会成功，快照只接受全部来自远程的配置
+  for _, ns := range client.Status().Namespaces {
+    if ns.SourceType != agollo.REMOTE {
+      return fmt.Errorf("namespace %s not loaded from remote: %v",
+        ns.Namespace, ns.LastError)
+    }
+  }
+
+  data, err := client.Snapshot()
+  if err != nil {
+    return err
+  }
+  return ioutil.WriteFile(out, data, 0600)
+}
@@ -0,0 +1,246 @@
+package configcenter
+
+import (
+  "encoding/json"
+  "net/http"
+  "sort"
+  "time"
+
+  "github.com/huchangwei/agollo"
+)
+
+// AdminHandler 调试与管理接口，挂载时去掉前缀，例如
+//
+//   mux.Handle("/debug/agollo/", http.StripPrefix("/debug/agollo",
+//     cfgCenter.AdminHandler()))
+//
+//   GET  /namespaces                 所有 namespace 的状态与整体健康状态
+//   GET  /values?namespace=xxx       namespace 当前生效的值，与 explain 一致，
+//                                    敏感的值会被隐藏，见 Conf.SensitiveKeys
+//   GET  /explain?namespace=xxx&key=xxx
+//                                    key 在每一层配置中的值与生效的一层
+//   GET  /history                    最近的配置变更，敏感的值会被隐藏
+//   POST /refresh[?namespace=xxx]    立即从远程刷新一个或全部 namespace，
+//                                    未配置的 namespace 返回 404
+func (c *ConfigCenter) AdminHandler() http.Handler {
+  mux := http.NewServeMux()
+  mux.HandleFunc("/namespaces", c.serveNamespaces)
+  mux.HandleFunc("/values", c.serveValues)
+  mux.HandleFunc("/explain", c.serveExplain)
+  mux.HandleFunc("/history", c.serveHistory)
+  mux.HandleFunc("/refresh", c.serveRefresh)
+  return mux
+}
+
+type namespaceView struct {
+  Namespace           string    `json:"namespace"`
+  SourceType          string    `json:"sourceType"`
+  ReleaseKey          string    `json:"releaseKey"`
+  NotificationID      int       `json:"notificationId"`
+  LastFetchTime       time.Time `json:"lastFetchTime"`
+  LastError           string    `json:"lastError,omitempty"`
+  ConsecutiveFailures int       `json:"consecutiveFailures"`
+  EverRemote          bool      `json:"everRemote"`
+}
+
+type changeView struct {
+  Key        string      `json:"key"`
+  ChangeType string      `json:"changeType"`
+  OldValue   interface{} `json:"oldValue,omitempty"`
+  NewValue   interface{} `json:"newValue,omitempty"`
+}
+
+type historyView struct {
+  Time       time.Time    `json:"time"`
+  Namespace  string       `json:"namespace"`
+  ReleaseKey string       `json:"releaseKey"`
+  Changes    []changeView `json:"changes"`
+}
+
+func (c *ConfigCenter) serveNamespaces(w http.ResponseWriter, r *http.Request) {
+  status := c.Status()
+  namespaces := make([]namespaceView, 0, len(status.Namespaces))
+  for _, ns := range status.Namespaces {
+    view := namespaceView{
+      Namespace:           ns.Namespace,
+      SourceType:          ns.SourceType.String(),
+      ReleaseKey:          ns.ReleaseKey,
+      NotificationID:      ns.NotificationID,
+      LastFetchTime:       ns.LastFetchTime,
+      ConsecutiveFailures: ns.ConsecutiveFailures,
+      EverRemote:          ns.EverRemote,
+    }
+    if ns.LastError != nil {
+      view.LastError = ns.LastError.Error()
+    }
+    namespaces = append(namespaces, view)
+  }
+
+  ret := map[string]interface{}{
+    "health":       status.Health.String(),
+    "pollFailures": status.PollFailures,
+    "namespaces":   namespaces,
+  }
+  if len(status.EnvOverrides) != 0 {
+    ret["envOverrides"] = status.EnvOverrides
+  }
+  if status.LastPollError != nil {
+    ret["lastPollError"] = status.LastPollError.Error()
+  }
+  writeJSON(w, http.StatusOK, ret)
+}
+
+func (c *ConfigCenter) serveValues(w http.ResponseWriter, r *http.Request) {
+  namespace := r.URL.Query().Get("namespace")
+  effective := agollo.Effective(namespace)
+  if len(effective) == 0 {
+    writeJSON(w, http.StatusNotFound, map[string]string{
+      "error": "namespace not loaded: " + namespace,
+    })
+    return
+  }
+
+  // 与 Explain 一致：每个 key 取生效的一层的值，不解密，敏感的值会被隐藏，
+  // 只读内存，不读磁盘上的备份
+  values := make(map[string]interface{}, len(effective))
+  sources := make(map[string]string, len(effective))
+  for k, v := range effective {
+    values[k] = toJSONValue(v.Value)
+    sources[k] = v.Type.String()
+  }
+  info := agollo.GetSourceInfo(namespace)
+  writeJSON(w, http.StatusOK, map[string]interface{}{
+    "namespace":  namespace,
+    "sourceType": info.Type.String(),
+    "releaseKey": agollo.GetReleaseKey(namespace),
+    "values":     values,
+    "sources":    sources,
+  })
+}
+
+func (c *ConfigCenter) serveExplain(w http.ResponseWriter, r *http.Request) {
+  query := r.URL.Query()
+  e := c.Explain(query.Get("namespace"), query.Get("key"))
+  layers := make([]map[string]interface{}, 0, len(e.Layers))
+  for _, l := range e.Layers {
+    layer := map[string]interface{}{
+      "sourceType": l.Type.String(),
+      "found":      l.Found,
+    }
+    if l.Found {
+      layer["value"] = toJSONValue(l.Value)
+    }
+    if len(l.Note) != 0 {
+      layer["note"] = l.Note
+    }
+    layers = append(layers, layer)
+  }
+  writeJSON(w, http.StatusOK, map[string]interface{}{
+    "namespace": e.Namespace,
+    "key":       e.Key,
+    "winner":    e.Winner.String(),
+    "reason":    e.Reason,
+    "layers":    layers,
+  })
+}
+
+func (c *ConfigCenter) serveHistory(w http.ResponseWriter, r *http.Request) {
+  history := c.History()
+  ret := make([]historyView, 0, len(history))
+  for _, h := range history {
+    view := historyView{
+      Time:       h.Time,
+      Namespace:  h.Event.Namespace,
+      ReleaseKey: h.Event.ReleaseKey,
+    }
+    for k, change := range h.Event.Changes {
+      view.Changes = append(view.Changes, changeView{
+        Key:        k,
+        ChangeType: change.ChangeType.String(),
+        OldValue:   toJSONValue(agollo.Mask(k, change.OldValue)),
+        NewValue:   toJSONValue(agollo.Mask(k, change.NewValue)),
+      })
+    }
+    sort.Slice(view.Changes, func(i, j int) bool {
+      return view.Changes[i].Key < view.Changes[j].Key
+    })
+    ret = append(ret, view)
+  }
+  writeJSON(w, http.StatusOK, ret)
+}
+
+func (c *ConfigCenter) serveRefresh(w http.ResponseWriter, r *http.Request) {
+  if r.Method != http.MethodPost {
+    w.Header().Set("Allow", http.MethodPost)
+    writeJSON(w, http.StatusMethodNotAllowed, map[string]string{
+      "error": "use POST",
+    })
+    return
+  }
+
+  var namespaces []string
+  if namespace := r.URL.Query().Get("namespace"); len(namespace) != 0 {
+    if !c.isKnownNamespace(namespace) {
+      writeJSON(w, http.StatusNotFound, map[string]string{
+        "error": "unknown namespace: " + namespace,
+      })
+      return
+    }
+    namespaces = append(namespaces, namespace)
+  }
+  if err := agollo.Refresh(namespaces...); err != nil {
+    writeJSON(w, http.StatusBadGateway, map[string]string{
+      "error": err.Error(),
+    })
+    return
+  }
+  c.serveNamespaces(w, r)
+}
+
+// isKnownNamespace namespace 已配置或已加载
+func (c *ConfigCenter) isKnownNamespace(namespace string) bool {
+  for _, ns := range c.Status().Namespaces {
+    if ns.Namespace == namespace {
+      return true
+    }
+  }
+  return false
+}
+
+// toJSONValue yaml 的 map[interface{}]interface{} 无法直接编码为 json
+func toJSONValue(value interface{}) interface{} {
+  switch v := value.(type) {
+  case map[interface{}]interface{}:
+    ret := make(map[string]interface{}, len(v))
+    for k, val := range v {
+      if ks, ok := k.(string); ok {
+        ret[ks] = toJSONValue(val)
+      } else {
+        b, _ := json.Marshal(k)
+        ret[string(b)] = toJSONValue(val)
+      }
+    }
+    return ret
+  case map[string]interface{}:
+    ret := make(map[string]interface{}, len(v))
+    for k, val := range v {
+      ret[k] = toJSONValue(val)
+    }
+    return ret
+  case []interface{}:
+    ret := make([]interface{}, len(v))
+    for i, val := range v {
+      ret[i] = toJSONValue(val)
+    }
+    return ret
+  }
+  return value
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+  w.Header().Set("Content-Type", "application/json; charset=utf-8")
+  w.WriteHeader(code)
+  enc := json.NewEncoder(w)
+  enc.SetIndent("", "  ")
+  enc.Encode(v)
+}
@@ -0,0 +1,147 @@
+package configcenter
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "testing"
+
+  "github.com/huchangwei/agollo"
+)
+
+// startAdminTestClient 以文件模式启动 agollo，不需要 HttpConfigServer
+func startAdminTestClient(t *testing.T) *ConfigCenter {
+  dir, err := ioutil.TempDir("", "configcenter")
+  if err != nil {
+    t.Fatal(err)
+  }
+  t.Cleanup(func() { os.RemoveAll(dir) })
+  if err := ioutil.WriteFile(filepath.Join(dir, "application.properties"),
+    []byte("apollo=admin\npassword=pa55\ntimeout=5s\nempty=\n"),
+    0600); err != nil {
+    t.Fatal(err)
+  }
+  if err := agollo.StartWithConf(&agollo.Conf{
+    AppID:          "app-apollo-demo",
+    NameSpaceNames: []string{"application"},
+    Mode:           agollo.ModeFile,
+    ConfigDir:      dir,
+    Persist:        agollo.PersistNever,
+  }); err != nil {
+    t.Fatal(err)
+  }
+  t.Cleanup(func() { agollo.Stop() })
+  return newTestConfigCenter(CallBackPolicy{})
+}
+
+func serveAdmin(t *testing.T, c *ConfigCenter, method, target string,
+  code int) map[string]interface{} {
+  w := httptest.NewRecorder()
+  c.AdminHandler().ServeHTTP(w, httptest.NewRequest(method, target, nil))
+  if w.Code != code {
+    t.Fatalf("%s %s: got %d %s", method, target, w.Code, w.Body)
+  }
+  var ret map[string]interface{}
+  if target != "/history" {
+    if err := json.Unmarshal(w.Body.Bytes(), &ret); err != nil {
+      t.Fatal(err)
+    }
+  }
+  return ret
+}
+
+// /values 返回各层合并后生效的值，与 explain 一致，敏感的值被隐藏
+func TestConfigCenter_AdminHandler_values(t *testing.T) {
+  c := startAdminTestClient(t)
+  agollo.SetOverride("application", "timeout", "10s")
+  agollo.RegisterDefault("application", "empty", "3")
+  agollo.RegisterDefault("application", "retries", "2")
+
+  ret := serveAdmin(t, c, http.MethodGet, "/values?namespace=application",
+    http.StatusOK)
+  values := ret["values"].(map[string]interface{})
+  sources := ret["sources"].(map[string]interface{})
+  for key, want := range map[string][2]string{
+    "apollo":   {"admin", "FILE_MODE"},
+    "password": {agollo.MaskedValue, "FILE_MODE"},
+    "timeout":  {"10s", "OVERRIDE"},
+    "empty":    {"3", "REGISTERED"},
+    "retries":  {"2", "REGISTERED"},
+  } {
+    if values[key] != want[0] || sources[key] != want[1] {
+      t.Errorf("%s: got %v %v", key, values[key], sources[key])
+    }
+    e := c.Explain("application", key)
+    if e.Winner.String() != want[1] {
+      t.Errorf("%s: explain got %s", key, e.Winner)
+    }
+  }
+
+  serveAdmin(t, c, http.MethodGet, "/values?namespace=missing",
+    http.StatusNotFound)
+}
+
+func TestConfigCenter_AdminHandler_explain(t *testing.T) {
+  c := startAdminTestClient(t)
+  agollo.SetOverride("application", "timeout", "10s")
+
+  ret := serveAdmin(t, c, http.MethodGet,
+    "/explain?namespace=application&key=timeout", http.StatusOK)
+  layers := ret["layers"].([]interface{})
+  if ret["winner"] != "OVERRIDE" || len(layers) == 0 ||
+    layers[0].(map[string]interface{})["value"] != "10s" {
+    t.Errorf("got %v", ret)
+  }
+}
+
+func TestConfigCenter_AdminHandler_namespaces(t *testing.T) {
+  c := startAdminTestClient(t)
+  ret := serveAdmin(t, c, http.MethodGet, "/namespaces", http.StatusOK)
+  namespaces := ret["namespaces"].([]interface{})
+  ns := namespaces[0].(map[string]interface{})
+  if len(namespaces) != 1 || ns["namespace"] != "application" ||
+    ns["sourceType"] != "FILE_MODE" {
+    t.Errorf("got %v", ret)
+  }
+}
+
+// 历史中敏感的值被隐藏
+func TestConfigCenter_AdminHandler_history(t *testing.T) {
+  c := startAdminTestClient(t)
+  c.recordHistory(&agollo.ChangeEvent{
+    Namespace: "application",
+    Changes: map[string]*agollo.Change{
+      "password": {ChangeType: MODIFY, OldValue: "old", NewValue: "new"},
+    },
+  })
+
+  w := httptest.NewRecorder()
+  c.AdminHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet,
+    "/history", nil))
+  var history []historyView
+  if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil {
+    t.Fatal(err)
+  }
+  if len(history) != 1 || len(history[0].Changes) != 1 ||
+    history[0].Changes[0].NewValue != agollo.MaskedValue ||
+    history[0].Changes[0].OldValue != agollo.MaskedValue {
+    t.Errorf("got %+v", history)
+  }
+}
+
+// 未配置的 namespace 返回 404，只接受 POST
+func TestConfigCenter_AdminHandler_refresh(t *testing.T) {
+  c := startAdminTestClient(t)
+  serveAdmin(t, c, http.MethodPost, "/refresh?namespace=missing",
+    http.StatusNotFound)
+  serveAdmin(t, c, http.MethodGet, "/refresh", http.StatusMethodNotAllowed)
+  ret := serveAdmin(t, c, http.MethodPost, "/refresh?namespace=application",
+    http.StatusOK)
+  if ret["health"] != "HEALTHY" {
+    t.Errorf("got %v", ret)
+  }
+  serveAdmin(t, c, http.MethodPost, "/refresh", http.StatusOK)
+}
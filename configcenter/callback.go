@@ -0,0 +1,193 @@
+package configcenter
+
+import (
+  "errors"
+  "fmt"
+  "time"
+
+  "github.com/huchangwei/agollo"
+)
+
+const callBackErrorChanSize = 100
+
+var (
+  // ErrCallBackTimeout 回调执行超时
+  ErrCallBackTimeout = errors.New("configcenter: callback timeout")
+  // ErrCallBackDropped 串行投递模式下协程的队列已满，回调未执行
+  ErrCallBackDropped = errors.New("configcenter: callback dropped, " +
+    "delivery queue full")
+)
+
+// CallBackPolicy 回调的错误处理策略，零值即不做任何处理
+type CallBackPolicy struct {
+  // RecoverPanic 捕获回调中的 panic，作为错误上报
+  RecoverPanic bool
+  // Timeout 单次回调的超时时间，0 不限制。超时只用于及时上报，回调不会被取消，
+  // 也不会重试，下一个回调在它结束后才会执行
+  Timeout time.Duration
+  // MaxRetries 回调返回错误或 panic 后的最大重试次数，超时不重试
+  MaxRetries int
+  // RetryBackoff 第一次重试前的等待时间，之后每次翻倍
+  RetryBackoff time.Duration
+  // ErrorHook 回调最终失败时调用
+  ErrorHook func(*CallBackError)
+  // MarkNotApplied 回调最终失败时将 namespace 标记为未生效
+  MarkNotApplied bool
+}
+
+// CallBackError 回调最终失败的信息
+type CallBackError struct {
+  Namespace  string
+  Key        string
+  ReleaseKey string
+  Err        error
+}
+
+func (e *CallBackError) Error() string {
+  return fmt.Sprintf("configcenter: callback namespace=%s key=%s "+
+    "releaseKey=%s: %v", e.Namespace, e.Key, e.ReleaseKey, e.Err)
+}
+
+// SetCallBackPolicy 设置回调的错误处理策略
+func (c *ConfigCenter) SetCallBackPolicy(policy CallBackPolicy) {
+  c.Lock()
+  defer c.Unlock()
+  c.policy = policy
+}
+
+// WatchCallBackErrors 获取回调失败的通知，通道满时丢弃
+func (c *ConfigCenter) WatchCallBackErrors() <-chan *CallBackError {
+  c.Lock()
+  defer c.Unlock()
+  if c.errChan == nil {
+    c.errChan = make(chan *CallBackError, callBackErrorChanSize)
+  }
+  return c.errChan
+}
+
+// IsApplied namespace 的所有回调是否都已成功执行
+func (c *ConfigCenter) IsApplied(namespace string) bool {
+  c.RLock()
+  defer c.RUnlock()
+  return len(c.notApplied[namespace]) == 0
+}
+
+// runCallBack 按策略执行回调，失败时上报。超时的回调不会被取消，也不会重试，
+// 超时立即上报，之后等待其结束再返回，因此顺序投递模式下同一回调不会重叠执行
+func (c *ConfigCenter) runCallBack(namespace, key, releaseKey string,
+  change *agollo.Change, callback ChangeCallBackFunc) {
+  c.RLock()
+  policy := c.policy
+  c.RUnlock()
+
+  backoff := policy.RetryBackoff
+  var err error
+  for i := 0; ; i++ {
+    var running <-chan struct{}
+    running, err = policy.call(callback, namespace, key, releaseKey, change)
+    if err == ErrCallBackTimeout {
+      c.failCallBack(policy, namespace, key, releaseKey, err)
+      select {
+      case <-c.stopChan:
+      case <-running:
+      }
+      return
+    }
+    if err == nil || i >= policy.MaxRetries {
+      break
+    }
+    select {
+    case <-c.stopChan:
+      return
+    case <-time.After(backoff):
+    }
+    backoff *= 2
+  }
+
+  if err == nil {
+    c.markApplied(namespace, key)
+    return
+  }
+  c.failCallBack(policy, namespace, key, releaseKey, err)
+}
+
+// failCallBack 上报最终失败的回调
+func (c *ConfigCenter) failCallBack(policy CallBackPolicy, namespace, key,
+  releaseKey string, err error) {
+  cbErr := &CallBackError{
+    Namespace:  namespace,
+    Key:        key,
+    ReleaseKey: releaseKey,
+    Err:        err,
+  }
+  if policy.MarkNotApplied {
+    c.markNotApplied(namespace, key, releaseKey)
+  }
+  if policy.ErrorHook != nil {
+    policy.ErrorHook(cbErr)
+  }
+  c.RLock()
+  errChan := c.errChan
+  c.RUnlock()
+  if errChan != nil {
+    select {
+    case errChan <- cbErr:
+    default:
+    }
+  }
+}
+
+// call 执行一次回调，超时返回 ErrCallBackTimeout，此时回调仍在运行，
+// running 在其结束时关闭
+func (p CallBackPolicy) call(callback ChangeCallBackFunc, namespace, key,
+  releaseKey string, change *agollo.Change) (running <-chan struct{},
+  err error) {
+  if p.Timeout <= 0 {
+    return nil, p.safeCall(callback, namespace, key, releaseKey, change)
+  }
+
+  done := make(chan struct{})
+  var callErr error
+  go func() {
+    defer close(done)
+    callErr = p.safeCall(callback, namespace, key, releaseKey, change)
+  }()
+  select {
+  case <-done:
+    return done, callErr
+  case <-time.After(p.Timeout):
+    return done, ErrCallBackTimeout
+  }
+}
+
+func (p CallBackPolicy) safeCall(callback ChangeCallBackFunc, namespace, key,
+  releaseKey string, change *agollo.Change) (err error) {
+  if p.RecoverPanic {
+    defer func() {
+      if r := recover(); r != nil {
+        err = fmt.Errorf("configcenter: callback panic: %v", r)
+      }
+    }()
+  }
+  return callback(namespace, key, releaseKey, change)
+}
+
+func (c *ConfigCenter) markNotApplied(namespace, key, releaseKey string) {
+  c.Lock()
+  defer c.Unlock()
+  if c.notApplied == nil {
+    c.notApplied = make(map[string]map[string]string)
+  }
+  keys, ok := c.notApplied[namespace]
+  if !ok {
+    keys = make(map[string]string)
+    c.notApplied[namespace] = keys
+  }
+  keys[key] = releaseKey
+}
+
+func (c *ConfigCenter) markApplied(namespace, key string) {
+  c.Lock()
+  defer c.Unlock()
+  delete(c.notApplied[namespace], key)
+}
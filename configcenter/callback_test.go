@@ -0,0 +1,117 @@
+package configcenter
+
+import (
+  "errors"
+  "sync/atomic"
+  "testing"
+  "time"
+
+  "github.com/huchangwei/agollo"
+)
+
+func newTestConfigCenter(policy CallBackPolicy) *ConfigCenter {
+  c := &ConfigCenter{stopChan: make(chan struct{})}
+  c.SetCallBackPolicy(policy)
+  return c
+}
+
+// 返回错误的回调按 MaxRetries 重试，最终失败上报到 errChan
+func TestConfigCenter_runCallBack_retry(t *testing.T) {
+  c := newTestConfigCenter(CallBackPolicy{
+    MaxRetries:     2,
+    RetryBackoff:   time.Millisecond,
+    MarkNotApplied: true,
+  })
+  errs := c.WatchCallBackErrors()
+  errFailed := errors.New("failed")
+  var calls int32
+  c.runCallBack("application", "timeout", "r1", &agollo.Change{},
+    func(_, _, _ string, _ *agollo.Change) error {
+      atomic.AddInt32(&calls, 1)
+      return errFailed
+    })
+
+  if calls != 3 {
+    t.Errorf("calls: got %d, want 3", calls)
+  }
+  select {
+  case e := <-errs:
+    if e.Namespace != "application" || e.Key != "timeout" ||
+      e.ReleaseKey != "r1" || e.Err != errFailed {
+      t.Errorf("error: got %v", e)
+    }
+  default:
+    t.Error("error: not reported")
+  }
+  if c.IsApplied("application") {
+    t.Error("applied: got true")
+  }
+
+  // 重试成功后恢复为已生效
+  calls = 0
+  c.runCallBack("application", "timeout", "r2", &agollo.Change{},
+    func(_, _, _ string, _ *agollo.Change) error {
+      if atomic.AddInt32(&calls, 1) == 1 {
+        return errFailed
+      }
+      return nil
+    })
+  if calls != 2 || !c.IsApplied("application") || len(errs) != 0 {
+    t.Errorf("recovered: got calls=%d applied=%v errors=%d", calls,
+      c.IsApplied("application"), len(errs))
+  }
+}
+
+// panic 被捕获并作为错误上报
+func TestConfigCenter_runCallBack_panic(t *testing.T) {
+  c := newTestConfigCenter(CallBackPolicy{RecoverPanic: true})
+  errs := c.WatchCallBackErrors()
+  c.runCallBack("application", "timeout", "r1", &agollo.Change{},
+    func(_, _, _ string, _ *agollo.Change) error {
+      panic("boom")
+    })
+  if e := <-errs; e.Err == nil {
+    t.Errorf("panic: got %v", e)
+  }
+}
+
+// 超时立即上报，不重试，等待回调结束后才返回
+func TestConfigCenter_runCallBack_timeout(t *testing.T) {
+  c := newTestConfigCenter(CallBackPolicy{
+    Timeout:      10 * time.Millisecond,
+    MaxRetries:   3,
+    RetryBackoff: time.Millisecond,
+  })
+  errs := c.WatchCallBackErrors()
+  release := make(chan struct{})
+  var calls int32
+  returned := make(chan struct{})
+  go func() {
+    defer close(returned)
+    c.runCallBack("application", "timeout", "r1", &agollo.Change{},
+      func(_, _, _ string, _ *agollo.Change) error {
+        atomic.AddInt32(&calls, 1)
+        <-release
+        return nil
+      })
+  }()
+
+  select {
+  case e := <-errs:
+    if e.Err != ErrCallBackTimeout {
+      t.Errorf("error: got %v", e)
+    }
+  case <-time.After(time.Second):
+    t.Fatal("timeout: not reported")
+  }
+  select {
+  case <-returned:
+    t.Error("returned before the callback finished")
+  case <-time.After(50 * time.Millisecond):
+  }
+  close(release)
+  <-returned
+  if n := atomic.LoadInt32(&calls); n != 1 {
+    t.Errorf("calls: got %d, want 1", n)
+  }
+}
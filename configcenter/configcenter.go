@@ -1,6 +1,8 @@
 package configcenter
 
 import (
+  "errors"
+  "sort"
   "sync"
   "github.com/huchangwei/agollo"
 )
@@ -8,7 +10,19 @@ import (
 type CallBackFunc func(oldValue, newValue interface{},
   changeType string) error
 
-type configInstance map[string]CallBackFunc
+// ChangeCallBackFunc 带类型的回调，change.ChangeType 可直接与 ADD/MODIFY/DELETE 比较
+type ChangeCallBackFunc func(namespace, key, releaseKey string,
+  change *agollo.Change) error
+
+// toChangeCallBack 将字符串形式的回调适配为 ChangeCallBackFunc
+func (f CallBackFunc) toChangeCallBack() ChangeCallBackFunc {
+  return func(_, _, _ string, change *agollo.Change) error {
+    return f(change.OldValue, change.NewValue, change.ChangeType.String())
+  }
+}
+
+// configInstance 一个 namespace 的监听，每个 key 的监听按优先级排序
+type configInstance map[string][]*watcher
 
 var (
   // the value is from REMOTE
@@ -17,6 +31,27 @@ var (
   LOCAL = agollo.LOCAL
   // DEFAULT ...
   DEFAULT = agollo.DEFAULT
+  // STALE a LOCAL value older than Conf.MaxLocalAge
+  STALE = agollo.STALE
+  // EMBEDDED the value is from the snapshot compiled into the binary
+  EMBEDDED = agollo.EMBEDDED
+  // OVERRIDE the value is pinned in process by SetOverride
+  OVERRIDE = agollo.OVERRIDE
+  // ENV the value is overridden by an environment variable
+  ENV = agollo.ENV
+  // FILE the value is overridden by the local override file
+  FILE = agollo.FILE
+  // REGISTERED the value is a default registered by RegisterDefault
+  REGISTERED = agollo.REGISTERED
+  // FILE_MODE the value is read from Conf.ConfigDir in file mode
+  FILE_MODE = agollo.FILE_MODE
+
+  // ADD a new value
+  ADD = agollo.ADD
+  // MODIFY a old value
+  MODIFY = agollo.MODIFY
+  // DELETE ...
+  DELETE = agollo.DELETE
 )
 
 type ConfigCenter struct {
@@ -24,41 +59,65 @@ type ConfigCenter struct {
   watches   map[string]configInstance
   watchChan <-chan *agollo.ChangeEvent
   stopChan  chan struct{}
+
+  policy     CallBackPolicy
+  errChan    chan *CallBackError
+  notApplied map[string]map[string]string
+
+  mode    DeliveryMode
+  workers int
+  pool    *deliveryPool
+  dropped uint64
+
+  history []HistoryEntry
 }
 
+// Init 启动时所有来源都没有配置会返回 *agollo.PreloadError，此时仍会监听变更，
+// 阿波罗恢复后回调照常触发
 func (c *ConfigCenter) Init(appConfigPath string) error {
 
   err := agollo.StartWithConfFile(appConfigPath)
-  if err != nil {
+  if !isPreloadError(err) && err != nil {
     return err
   }
 
-  c.stopChan = make(chan struct{})
-  c.watches = make(map[string]configInstance)
-  c.watchChan = agollo.WatchUpdate()
-
-  go c.watchConfigUpdatesProc()
+  c.startWatch()
 
   return err
 }
 
 func (c *ConfigCenter) InitWithConf(conf *agollo.Conf) error {
   err := agollo.StartWithConf(conf)
-  if err != nil {
+  if !isPreloadError(err) && err != nil {
     return err
   }
 
+  c.startWatch()
+
+  return err
+}
+
+func isPreloadError(err error) bool {
+  var preloadErr *agollo.PreloadError
+  return errors.As(err, &preloadErr)
+}
+
+func (c *ConfigCenter) startWatch() {
+  c.Lock()
   c.stopChan = make(chan struct{})
   c.watches = make(map[string]configInstance)
   c.watchChan = agollo.WatchUpdate()
+  if c.mode != DeliveryConcurrent {
+    c.pool = newDeliveryPool(c.workers, c.stopChan)
+  }
+  c.Unlock()
 
   go c.watchConfigUpdatesProc()
-
-  return err
 }
 
 func (c *ConfigCenter) UnInit() {
   close(c.stopChan)
+  agollo.CancelWatch(c.watchChan)
   agollo.Stop()
 }
 
@@ -69,15 +128,45 @@ func (c *ConfigCenter) RegisterKeyWatchFuncDefault(key string,
   c.RegisterKeyWatchFunc(defaultNamespace, key, callback)
 }
 
+// key 可以是第一级的 key，也可以是 .yaml/.json 中的嵌套路径，如 spouse.name、
+// spouse.info[0]，嵌套路径需要开启 Conf.DeepDiff，该路径或其下任一节点变化时回调
 func (c *ConfigCenter) RegisterKeyWatchFunc(namespace, key string,
   callback CallBackFunc) {
+  c.RegisterKeyWatchFuncWithPriority(namespace, key, 0, callback)
+}
+
+// priority 越小越先执行，同一 key 的多个监听在所有模式下都按优先级依次执行，
+// 优先级相同时按注册顺序；不同 key 之间的顺序仅在 DeliveryPerNamespace 模式下保证
+func (c *ConfigCenter) RegisterKeyWatchFuncWithPriority(namespace, key string,
+  priority int, callback CallBackFunc) {
+  c.RegisterKeyChangeFuncWithPriority(namespace, key, priority,
+    callback.toChangeCallBack())
+}
+
+// 与 RegisterKeyWatchFunc 相同，回调中拿到带类型的 agollo.Change
+func (c *ConfigCenter) RegisterKeyChangeFunc(namespace, key string,
+  callback ChangeCallBackFunc) {
+  c.RegisterKeyChangeFuncWithPriority(namespace, key, 0, callback)
+}
+
+func (c *ConfigCenter) RegisterKeyChangeFuncWithPriority(namespace,
+  key string, priority int, callback ChangeCallBackFunc) {
   c.Lock()
   defer c.Unlock()
 
-  instance := make(map[string]CallBackFunc)
-  instance[key] = callback
-
-  c.watches[namespace] = instance
+  instance, ok := c.watches[namespace]
+  if !ok {
+    instance = make(configInstance)
+    c.watches[namespace] = instance
+  }
+  watchers := append(instance[key], &watcher{
+    callback: callback,
+    priority: priority,
+  })
+  sort.SliceStable(watchers, func(i, j int) bool {
+    return watchers[i].priority < watchers[j].priority
+  })
+  instance[key] = watchers
 }
 
 func (c *ConfigCenter) GetConfigValue(key, defaultValue string) (interface{},agollo.SourceType, error) {
@@ -89,12 +178,48 @@ defaultValue interface{}) (interface{}, agollo.SourceType, error)  {
   return agollo.GetStringValueWithNameSpace(namespace, key, defaultValue)
 }
 
+// 与 GetConfigValueWithNameSpace 不同，空字符串会原样返回，found 为 false 表示 key 不存在
+func (c *ConfigCenter) Lookup(namespace, key string) (interface{},
+  agollo.SourceType, bool) {
+  return agollo.Lookup(namespace, key)
+}
+
+func (c *ConfigCenter) Has(namespace, key string) bool {
+  return agollo.Has(namespace, key)
+}
+
+func (c *ConfigCenter) Keys(namespace string) []string {
+  return agollo.Keys(namespace)
+}
+
+func (c *ConfigCenter) GetAll(namespace string) agollo.Configuration {
+  return agollo.GetAll(namespace)
+}
+
+func (c *ConfigCenter) Namespaces() []string {
+  return agollo.Namespaces()
+}
+
+// 列出 key 在每一层配置中的值，以及最终生效的是哪一层、原因，用于排查配置来源
+func (c *ConfigCenter) Explain(namespace, key string) agollo.Explanation {
+  return agollo.Explain(namespace, key)
+}
+
+// 每个 namespace 的来源、releaseKey、最近错误等，以及整体健康状态，可用于就绪探针
+func (c *ConfigCenter) Status() agollo.ClientStatus {
+  return agollo.Status()
+}
+
 func (c *ConfigCenter) watchConfigUpdatesProc() {
   for {
     select {
     case <-c.stopChan:
-      break
-    case updates := <-c.watchChan:
+      return
+    case updates, ok := <-c.watchChan:
+      if !ok {
+        return
+      }
+      c.recordHistory(updates)
       c.triggerConfigInstanceCallBack(updates)
     }
   }
@@ -104,19 +229,49 @@ func (c *ConfigCenter) triggerConfigInstanceCallBack(
   updates *agollo.ChangeEvent) {
   upNameSpace := updates.Namespace
   c.RLock()
-  cfgInstances, ok := c.watches[upNameSpace]
+  cfgInstances := c.watches[upNameSpace]
+  var tasks []*watchTask
+  for key, watchers := range cfgInstances {
+    upItem, ok := updates.Changes[key]
+    if !ok {
+      upItem, ok = updates.DeepChanges[key]
+    }
+    if !ok {
+      continue
+    }
+    for _, w := range watchers {
+      tasks = append(tasks, &watchTask{key: key, change: upItem, watcher: w})
+    }
+  }
+  mode, pool := c.mode, c.pool
   c.RUnlock()
 
-  if !ok {
+  if len(tasks) == 0 {
     return
   }
-  changes := updates.Changes
-  for uKey, upItem := range changes {
-    for key, callback := range cfgInstances {
-      if uKey == key {
-        go callback(upItem.OldValue, upItem.NewValue, upItem.ChangeType.String())
-      }
+  sortWatchTasks(tasks)
+  run := func(tasks []*watchTask) {
+    for _, t := range tasks {
+      c.runCallBack(upNameSpace, t.key, updates.ReleaseKey, t.change,
+        t.watcher.callback)
     }
   }
 
+  switch {
+  case pool == nil:
+    for _, keyTasks := range groupWatchTasks(tasks) {
+      go run(keyTasks)
+    }
+  case mode == DeliveryPerNamespace:
+    if !pool.submit(upNameSpace, func() { run(tasks) }) {
+      c.dropCallBacks(upNameSpace, updates.ReleaseKey, tasks)
+    }
+  default:
+    for key, keyTasks := range groupWatchTasks(tasks) {
+      keyTasks := keyTasks
+      if !pool.submit(upNameSpace+"/"+key, func() { run(keyTasks) }) {
+        c.dropCallBacks(upNameSpace, updates.ReleaseKey, keyTasks)
+      }
+    }
+  }
 }
@@ -4,7 +4,6 @@ import (
   "testing"
   "fmt"
   "time"
-  "apollo_go/configcenter"
 )
 
 //测试前先启动模拟的配置中心HttpConfigServer
@@ -164,7 +163,7 @@ func TestConfigCenter_GetConfigValue_SourceType(t *testing.T) {
   value, sourceType,  err := cfgCenter.GetConfigValueWithNameSpace("testyaml.yaml",
     "root", "default")
   fmt.Println(sourceType.String())
-  if sourceType == configcenter.DEFAULT{
+  if sourceType == DEFAULT{
     t.Log("测试sourceType成功")
   }else{
     t.Error("测试sourceType失败")
@@ -176,7 +175,7 @@ func TestConfigCenter_GetConfigValue_SourceType(t *testing.T) {
   value, sourceType,  err = cfgCenter.GetConfigValueWithNameSpace("testyaml.yaml",
     "spouse", "default")
   fmt.Println(sourceType.String())
-  if sourceType == configcenter.REMOTE{
+  if sourceType == REMOTE{
     t.Log("测试sourceType成功")
   }else{
     t.Error("测试sourceType失败")
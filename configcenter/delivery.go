@@ -0,0 +1,149 @@
+package configcenter
+
+import (
+  "hash/fnv"
+  "sort"
+  "sync/atomic"
+
+  "github.com/huchangwei/agollo"
+)
+
+const (
+  defaultDeliveryWorkers   = 4
+  defaultDeliveryQueueSize = 64
+)
+
+// DeliveryMode 回调的投递方式
+type DeliveryMode int
+
+const (
+  // DeliveryConcurrent 每个 key 一个 goroutine，不同 key 之间不保证顺序，
+  // 默认方式
+  DeliveryConcurrent DeliveryMode = iota
+  // DeliveryPerNamespace 同一 namespace 的回调按发布顺序串行执行，
+  // 同一次发布内按优先级执行
+  DeliveryPerNamespace
+  // DeliveryPerKey 同一 key 的回调按发布顺序串行执行，不同 key 之间不保证顺序
+  DeliveryPerKey
+)
+
+func (d DeliveryMode) String() string {
+  switch d {
+  case DeliveryConcurrent:
+    return "CONCURRENT"
+  case DeliveryPerNamespace:
+    return "PER_NAMESPACE"
+  case DeliveryPerKey:
+    return "PER_KEY"
+  }
+
+  return "UNKNOW"
+}
+
+// watcher 一个 key 的监听
+type watcher struct {
+  callback ChangeCallBackFunc
+  priority int
+}
+
+// watchTask 一次变更命中的监听
+type watchTask struct {
+  key     string
+  change  *agollo.Change
+  watcher *watcher
+}
+
+// SetDeliveryMode 设置回调投递方式，workers 为串行模式下的协程数，
+// 需要在 Init 之前调用。每个协程的队列可容纳 64 个任务，队列满时新的回调被
+// 丢弃并以 ErrCallBackDropped 上报，见 DroppedCallBacks，慢回调不会拖住轮询
+func (c *ConfigCenter) SetDeliveryMode(mode DeliveryMode, workers int) {
+  c.Lock()
+  defer c.Unlock()
+  if workers <= 0 {
+    workers = defaultDeliveryWorkers
+  }
+  c.mode = mode
+  c.workers = workers
+}
+
+// deliveryPool 有界的协程池，同一个 shard 的任务总是由同一个协程按提交顺序执行
+type deliveryPool struct {
+  queues   []chan func()
+  stopChan <-chan struct{}
+}
+
+func newDeliveryPool(workers int, stopChan <-chan struct{}) *deliveryPool {
+  pool := &deliveryPool{
+    queues:   make([]chan func(), workers),
+    stopChan: stopChan,
+  }
+  for i := range pool.queues {
+    pool.queues[i] = make(chan func(), defaultDeliveryQueueSize)
+    go pool.work(pool.queues[i])
+  }
+  return pool
+}
+
+func (p *deliveryPool) work(queue <-chan func()) {
+  for {
+    select {
+    case <-p.stopChan:
+      return
+    case task := <-queue:
+      task()
+    }
+  }
+}
+
+// submit 不阻塞，队列满时返回 false 由调用方上报丢弃，接收变更的协程与
+// agollo 的轮询不会因为慢回调而停顿
+func (p *deliveryPool) submit(shard string, task func()) bool {
+  h := fnv.New32a()
+  h.Write([]byte(shard))
+  queue := p.queues[h.Sum32()%uint32(len(p.queues))]
+  select {
+  case <-p.stopChan:
+    return true
+  case queue <- task:
+    return true
+  default:
+    return false
+  }
+}
+
+// dropCallBacks 上报因队列满被丢弃的回调
+func (c *ConfigCenter) dropCallBacks(namespace, releaseKey string,
+  tasks []*watchTask) {
+  atomic.AddUint64(&c.dropped, uint64(len(tasks)))
+  c.RLock()
+  policy := c.policy
+  c.RUnlock()
+  for _, t := range tasks {
+    c.failCallBack(policy, namespace, t.key, releaseKey, ErrCallBackDropped)
+  }
+}
+
+// DroppedCallBacks 因投递队列满被丢弃的回调总数
+func (c *ConfigCenter) DroppedCallBacks() uint64 {
+  return atomic.LoadUint64(&c.dropped)
+}
+
+// sortWatchTasks 按优先级从小到大排序，优先级相同时按 key 排序，
+// 同一 key 优先级相同的监听保持注册顺序
+func sortWatchTasks(tasks []*watchTask) {
+  sort.SliceStable(tasks, func(i, j int) bool {
+    if tasks[i].watcher.priority != tasks[j].watcher.priority {
+      return tasks[i].watcher.priority < tasks[j].watcher.priority
+    }
+    return tasks[i].key < tasks[j].key
+  })
+}
+
+// groupWatchTasks 按 key 分组，组内保持原有顺序
+func groupWatchTasks(tasks []*watchTask) map[string][]*watchTask {
+  ret := make(map[string][]*watchTask)
+  for _, t := range tasks {
+    ret[t.key] = append(ret[t.key], t)
+  }
+  return ret
+}
@@ -0,0 +1,174 @@
+package configcenter
+
+import (
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "reflect"
+  "testing"
+  "time"
+
+  "github.com/huchangwei/agollo"
+)
+
+// recordCallBack 回调执行时把 name 写入 calls
+func recordCallBack(calls chan<- string, name string) ChangeCallBackFunc {
+  return func(_, _, _ string, _ *agollo.Change) error {
+    calls <- name
+    return nil
+  }
+}
+
+func receiveCalls(t *testing.T, calls <-chan string, n int) []string {
+  var ret []string
+  for i := 0; i < n; i++ {
+    select {
+    case name := <-calls:
+      ret = append(ret, name)
+    case <-time.After(time.Second):
+      t.Fatalf("got %v, want %d calls", ret, n)
+    }
+  }
+  return ret
+}
+
+func newDeliveryTestConfigCenter(mode DeliveryMode) *ConfigCenter {
+  c := newTestConfigCenter(CallBackPolicy{})
+  c.watches = make(map[string]configInstance)
+  c.SetDeliveryMode(mode, 2)
+  if mode != DeliveryConcurrent {
+    c.pool = newDeliveryPool(c.workers, c.stopChan)
+  }
+  return c
+}
+
+var deliveryTestEvent = &agollo.ChangeEvent{
+  Namespace: "application",
+  Changes: map[string]*agollo.Change{
+    "db.url":     {ChangeType: agollo.MODIFY},
+    "cache.size": {ChangeType: agollo.MODIFY},
+  },
+}
+
+// 同一 key 的多个监听都会执行，在所有模式下按优先级、注册顺序执行
+func TestConfigCenter_triggerConfigInstanceCallBack_multipleWatchers(
+  t *testing.T) {
+  for _, mode := range []DeliveryMode{DeliveryConcurrent,
+    DeliveryPerNamespace, DeliveryPerKey} {
+    t.Run(mode.String(), func(t *testing.T) {
+      c := newDeliveryTestConfigCenter(mode)
+      defer close(c.stopChan)
+      calls := make(chan string, 4)
+      c.RegisterKeyChangeFuncWithPriority("application", "db.url", 10,
+        recordCallBack(calls, "log"))
+      c.RegisterKeyChangeFuncWithPriority("application", "db.url", 0,
+        recordCallBack(calls, "reconnect"))
+      c.RegisterKeyChangeFuncWithPriority("application", "db.url", 10,
+        recordCallBack(calls, "metrics"))
+
+      c.triggerConfigInstanceCallBack(deliveryTestEvent)
+      got := receiveCalls(t, calls, 3)
+      if want := []string{"reconnect", "log", "metrics"}; !reflect.DeepEqual(
+        got, want) {
+        t.Errorf("got %v, want %v", got, want)
+      }
+    })
+  }
+}
+
+// DeliveryPerNamespace 模式下不同 key 之间也按优先级执行
+func TestConfigCenter_triggerConfigInstanceCallBack_priority(t *testing.T) {
+  c := newDeliveryTestConfigCenter(DeliveryPerNamespace)
+  defer close(c.stopChan)
+  calls := make(chan string, 4)
+  c.RegisterKeyChangeFuncWithPriority("application", "cache.size", 10,
+    recordCallBack(calls, "resize"))
+  c.RegisterKeyChangeFuncWithPriority("application", "db.url", 0,
+    recordCallBack(calls, "reconnect"))
+  c.RegisterKeyChangeFuncWithPriority("application", "cache.size", 5,
+    recordCallBack(calls, "flush"))
+  c.RegisterKeyChangeFuncWithPriority("application", "missing", 0,
+    recordCallBack(calls, "missing"))
+
+  for i := 0; i < 2; i++ {
+    c.triggerConfigInstanceCallBack(deliveryTestEvent)
+  }
+  got := receiveCalls(t, calls, 6)
+  want := []string{"reconnect", "flush", "resize",
+    "reconnect", "flush", "resize"}
+  if !reflect.DeepEqual(got, want) {
+    t.Errorf("got %v, want %v", got, want)
+  }
+}
+
+// 回调一直不返回时队列满后丢弃新的回调，轮询照常应用后续的发布
+func TestConfigCenter_triggerConfigInstanceCallBack_stuckCallBack(
+  t *testing.T) {
+  dir, err := ioutil.TempDir("", "configcenter")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+  file := filepath.Join(dir, "application.properties")
+  if err := ioutil.WriteFile(file, []byte("apollo=v0\n"), 0600); err != nil {
+    t.Fatal(err)
+  }
+
+  c := &ConfigCenter{}
+  c.SetDeliveryMode(DeliveryPerNamespace, 1)
+  if err := c.InitWithConf(&agollo.Conf{
+    AppID:          "app-apollo-demo",
+    NameSpaceNames: []string{"application"},
+    Mode:           agollo.ModeFile,
+    ConfigDir:      dir,
+    Persist:        agollo.PersistNever,
+  }); err != nil {
+    t.Fatal(err)
+  }
+  defer c.UnInit()
+  release := make(chan struct{})
+  defer close(release)
+  c.RegisterKeyChangeFunc("application", "apollo",
+    func(_, _, _ string, _ *agollo.Change) error {
+      <-release
+      return nil
+    })
+
+  done := make(chan error, 1)
+  go func() {
+    for i := 1; i <= 2*defaultDeliveryQueueSize+2; i++ {
+      want := fmt.Sprintf("v%d", i)
+      if err := ioutil.WriteFile(file, []byte("apollo="+want+"\n"),
+        0600); err != nil {
+        done <- err
+        return
+      }
+      if err := agollo.Refresh("application"); err != nil {
+        done <- err
+        return
+      }
+      if value, _, _ := agollo.GetStringValue("apollo", ""); value != want {
+        done <- fmt.Errorf("got %v, want %s", value, want)
+        return
+      }
+    }
+    done <- nil
+  }()
+  select {
+  case err := <-done:
+    if err != nil {
+      t.Fatal(err)
+    }
+  case <-time.After(5 * time.Second):
+    t.Fatal("polling stalled")
+  }
+
+  deadline := time.Now().Add(time.Second)
+  for c.DroppedCallBacks() == 0 {
+    if time.Now().After(deadline) {
+      t.Fatal("dropped: got 0")
+    }
+    time.Sleep(10 * time.Millisecond)
+  }
+}
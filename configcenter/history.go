@@ -0,0 +1,36 @@
+package configcenter
+
+import (
+  "time"
+
+  "github.com/huchangwei/agollo"
+)
+
+const defaultHistorySize = 100
+
+// HistoryEntry 一次收到的配置变更
+type HistoryEntry struct {
+  Time  time.Time
+  Event *agollo.ChangeEvent
+}
+
+// History 最近收到的配置变更，按时间从旧到新
+func (c *ConfigCenter) History() []HistoryEntry {
+  c.RLock()
+  defer c.RUnlock()
+  ret := make([]HistoryEntry, 0, len(c.history))
+  return append(ret, c.history...)
+}
+
+func (c *ConfigCenter) recordHistory(updates *agollo.ChangeEvent) {
+  c.Lock()
+  defer c.Unlock()
+  if len(c.history) >= defaultHistorySize {
+    copy(c.history, c.history[1:])
+    c.history = c.history[:len(c.history)-1]
+  }
+  c.history = append(c.history, HistoryEntry{
+    Time:  time.Now(),
+    Event: updates,
+  })
+}